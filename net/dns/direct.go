@@ -7,6 +7,7 @@ package dns
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
 	"fmt"
 	"io"
@@ -16,8 +17,13 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"inet.af/netaddr"
+	"tailscale.com/health"
+	"tailscale.com/types/logger"
 	"tailscale.com/util/dnsname"
 )
 
@@ -26,8 +32,44 @@ const (
 	resolvConf = "/etc/resolv.conf"
 )
 
+const (
+	// watchDebounce is how long directManager waits after a resolv.conf
+	// change notification before re-checking ownership, to coalesce the
+	// burst of events that some tools (e.g. NetworkManager) generate for
+	// a single logical update.
+	watchDebounce = 100 * time.Millisecond
+
+	// maxFlaps is the number of times directManager will reclaim
+	// resolv.conf from a third party in quick succession before giving
+	// up and surfacing a health warning instead of fighting forever.
+	maxFlaps = 5
+
+	// flapResetInterval is how long directManager waits without seeing
+	// another takeover before it resets the flap counter.
+	flapResetInterval = time.Minute
+)
+
+// tailscaleOptions are the resolv.conf(5) "options" values that
+// Tailscale's DNS managers install by default, so that search-domain
+// lookups behave sanely regardless of what the replaced resolv.conf
+// had set. They're overridden by OSConfig.Options when a caller wants
+// something different.
+var tailscaleOptions = []string{"ndots:1", "timeout:2", "attempts:2"}
+
+// resolvOptionsOrDefault returns options, or tailscaleOptions if
+// options is empty. Every OSConfigurator that writes a resolv.conf(5)
+// "options" line should route through this, so that the Tailscale
+// defaults apply uniformly regardless of which configurator a system
+// ends up using.
+func resolvOptionsOrDefault(options []string) []string {
+	if len(options) == 0 {
+		return tailscaleOptions
+	}
+	return options
+}
+
 // writeResolvConf writes DNS configuration in resolv.conf format to the given writer.
-func writeResolvConf(w io.Writer, servers []netaddr.IP, domains []dnsname.FQDN) {
+func writeResolvConf(w io.Writer, servers []netaddr.IP, domains []dnsname.FQDN, options []string) {
 	io.WriteString(w, "# resolv.conf(5) file generated by tailscale\n")
 	io.WriteString(w, "# DO NOT EDIT THIS FILE BY HAND -- CHANGES WILL BE OVERWRITTEN\n\n")
 	for _, ns := range servers {
@@ -43,6 +85,14 @@ func writeResolvConf(w io.Writer, servers []netaddr.IP, domains []dnsname.FQDN)
 		}
 		io.WriteString(w, "\n")
 	}
+	if len(options) > 0 {
+		io.WriteString(w, "options")
+		for _, opt := range options {
+			io.WriteString(w, " ")
+			io.WriteString(w, opt)
+		}
+		io.WriteString(w, "\n")
+	}
 }
 
 func readResolv(r io.Reader) (config OSConfig, err error) {
@@ -71,12 +121,18 @@ func readResolv(r io.Reader) (config OSConfig, err error) {
 			config.SearchDomains = append(config.SearchDomains, fqdn)
 			continue
 		}
+
+		if strings.HasPrefix(line, "options") {
+			opts := strings.TrimPrefix(line, "options")
+			config.Options = append(config.Options, strings.Fields(opts)...)
+			continue
+		}
 	}
 
 	return config, nil
 }
 
-func (m directManager) readResolvFile(path string) (OSConfig, error) {
+func (m *directManager) readResolvFile(path string) (OSConfig, error) {
 	b, err := m.fs.ReadFile(path)
 	if err != nil {
 		return OSConfig{}, err
@@ -85,7 +141,7 @@ func (m directManager) readResolvFile(path string) (OSConfig, error) {
 }
 
 // readResolvConf reads DNS configuration from /etc/resolv.conf.
-func (m directManager) readResolvConf() (OSConfig, error) {
+func (m *directManager) readResolvConf() (OSConfig, error) {
 	return m.readResolvFile(resolvConf)
 }
 
@@ -141,33 +197,42 @@ func isResolvedRunning() bool {
 // directManager is an OSConfigurator which replaces /etc/resolv.conf with a file
 // generated from the given configuration, creating a backup of its old state.
 //
-// This way of configuring DNS is precarious, since it does not react
-// to the disappearance of the Tailscale interface.
-// The caller must call Down before program shutdown
-// or as cleanup if the program terminates unexpectedly.
+// This way of configuring DNS is precarious, since it does not by
+// itself react to the disappearance of the Tailscale interface.
+// The caller must call Close before program shutdown, or as cleanup
+// if the program terminates unexpectedly, and should call Run in a
+// goroutine if it wants directManager to reclaim /etc/resolv.conf
+// from third parties that overwrite it out from under us.
 type directManager struct {
-	fs wholeFileFS
+	logf logger.Logf
+	fs   wholeFileFS
+
+	mu       sync.Mutex // guards the following
+	config   OSConfig   // last config passed to SetDNS
+	flaps    int        // unexpected takeovers in a row, each within flapResetInterval of the previous
+	lastFlap time.Time
+	meta     *fileMeta // metadata of the resolv.conf we took over, if any
 }
 
-func newDirectManager() directManager {
-	return directManager{fs: directFS{}}
+func newDirectManager(logf logger.Logf) *directManager {
+	return &directManager{logf: logf, fs: directFS{}}
 }
 
-func newDirectManagerOnFS(fs wholeFileFS) directManager {
-	return directManager{fs: fs}
+func newDirectManagerOnFS(logf logger.Logf, fs wholeFileFS) *directManager {
+	return &directManager{logf: logf, fs: fs}
 }
 
 // ownedByTailscale reports whether /etc/resolv.conf seems to be a
 // tailscale-managed file.
-func (m directManager) ownedByTailscale() (bool, error) {
-	isRegular, err := m.fs.Stat(resolvConf)
+func (m *directManager) ownedByTailscale() (bool, error) {
+	fi, err := m.fs.Stat(resolvConf)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
 		}
 		return false, err
 	}
-	if !isRegular {
+	if !fi.Mode().IsRegular() {
 		return false, nil
 	}
 	bs, err := m.fs.ReadFile(resolvConf)
@@ -182,7 +247,7 @@ func (m directManager) ownedByTailscale() (bool, error) {
 
 // backupConfig creates or updates a backup of /etc/resolv.conf, if
 // resolv.conf does not currently contain a Tailscale-managed config.
-func (m directManager) backupConfig() error {
+func (m *directManager) backupConfig() error {
 	if _, err := m.fs.Stat(resolvConf); err != nil {
 		if os.IsNotExist(err) {
 			// No resolv.conf, nothing to back up. Also get rid of any
@@ -201,10 +266,25 @@ func (m directManager) backupConfig() error {
 		return nil
 	}
 
+	// Snapshot the metadata of the file we're about to take over, so
+	// that every subsequent atomic write can restore it. We only do
+	// this once: once we own resolv.conf, its on-disk metadata is
+	// whatever we last wrote, not the original owner's.
+	m.mu.Lock()
+	needMeta := m.meta == nil
+	m.mu.Unlock()
+	if needMeta {
+		if meta, ok, err := m.fs.ReadMeta(resolvConf); err == nil && ok {
+			m.mu.Lock()
+			m.meta = &meta
+			m.mu.Unlock()
+		}
+	}
+
 	return m.fs.Rename(resolvConf, backupConf)
 }
 
-func (m directManager) restoreBackup() error {
+func (m *directManager) restoreBackup() error {
 	if _, err := m.fs.Stat(backupConf); err != nil {
 		if os.IsNotExist(err) {
 			// No backup, nothing we can do.
@@ -236,23 +316,15 @@ func (m directManager) restoreBackup() error {
 	return nil
 }
 
-func (m directManager) SetDNS(config OSConfig) error {
-	if config.IsZero() {
-		if err := m.restoreBackup(); err != nil {
-			return err
-		}
-	} else {
-		if err := m.backupConfig(); err != nil {
-			return err
-		}
-
-		buf := new(bytes.Buffer)
-		writeResolvConf(buf, config.Nameservers, config.SearchDomains)
-		if err := atomicWriteFile(m.fs, resolvConf, buf.Bytes(), 0644); err != nil {
-			return err
-		}
+func (m *directManager) SetDNS(config OSConfig) error {
+	if err := m.installConfig(config); err != nil {
+		return err
 	}
 
+	m.mu.Lock()
+	m.config = config
+	m.mu.Unlock()
+
 	// We might have taken over a configuration managed by resolved,
 	// in which case it will notice this on restart and gracefully
 	// start using our configuration. This shouldn't happen because we
@@ -266,11 +338,43 @@ func (m directManager) SetDNS(config OSConfig) error {
 	return nil
 }
 
-func (m directManager) SupportsSplitDNS() bool {
+// installConfig writes config to /etc/resolv.conf, backing up
+// whatever was there before if it wasn't already Tailscale's doing.
+// It does not update m.config; callers that want SetDNS's bookkeeping
+// (used by Run to reinstall config after a third party clobbers the
+// file) should do that separately.
+func (m *directManager) installConfig(config OSConfig) error {
+	if config.IsZero() {
+		return m.restoreBackup()
+	}
+
+	if err := m.backupConfig(); err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	writeResolvConf(buf, config.Nameservers, config.SearchDomains, resolvOptionsOrDefault(config.Options))
+	if err := atomicWriteFile(m.fs, resolvConf, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	meta := m.meta
+	m.mu.Unlock()
+	if meta != nil {
+		if err := m.fs.WriteMeta(resolvConf, *meta); err != nil {
+			m.logf("dns: direct: restoring resolv.conf metadata: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (m *directManager) SupportsSplitDNS() bool {
 	return false
 }
 
-func (m directManager) GetBaseConfig() (OSConfig, error) {
+func (m *directManager) GetBaseConfig() (OSConfig, error) {
 	owned, err := m.ownedByTailscale()
 	if err != nil {
 		return OSConfig{}, err
@@ -283,7 +387,7 @@ func (m directManager) GetBaseConfig() (OSConfig, error) {
 	return m.readResolvFile(fileToRead)
 }
 
-func (m directManager) Close() error {
+func (m *directManager) Close() error {
 	// We used to keep a file for the tailscale config and symlinked
 	// to it, but then we stopped because /etc/resolv.conf being a
 	// symlink to surprising places breaks snaps and other sandboxing
@@ -326,6 +430,89 @@ func (m directManager) Close() error {
 	return nil
 }
 
+// Run watches /etc/resolv.conf for changes made by third parties
+// (DHCP clients, NetworkManager, cloud-init, container runtimes, ...)
+// and reclaims it whenever it stops being Tailscale-managed. It
+// blocks until ctx is done or the watch's event channel is closed.
+//
+// Run gives up reclaiming resolv.conf, and instead reports a health
+// warning, once it sees more than maxFlaps takeovers in a row with no
+// more than flapResetInterval between one takeover and the next, on
+// the assumption that something is fighting us for ownership of the
+// file and further retries would just make things worse.
+func (m *directManager) Run(ctx context.Context) error {
+	events, stop, err := m.fs.Watch(resolvConf)
+	if err != nil {
+		return fmt.Errorf("directManager.Run: %w", err)
+	}
+	defer stop()
+
+	var debounce *time.Timer
+	for {
+		var fire <-chan time.Time
+		if debounce != nil {
+			fire = debounce.C
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if debounce == nil {
+				debounce = time.NewTimer(watchDebounce)
+			} else {
+				debounce.Reset(watchDebounce)
+			}
+		case <-fire:
+			m.reclaimIfNeeded()
+		}
+	}
+}
+
+// reclaimIfNeeded re-takes ownership of /etc/resolv.conf if it has
+// stopped being Tailscale-managed, using the most recently set
+// config. It gives up and reports a health warning after too many
+// takeovers in a short window.
+func (m *directManager) reclaimIfNeeded() {
+	owned, err := m.ownedByTailscale()
+	if err != nil {
+		m.logf("dns: direct: checking resolv.conf ownership: %v", err)
+		return
+	}
+	if owned {
+		return
+	}
+
+	m.mu.Lock()
+	config := m.config
+	now := time.Now()
+	if now.Sub(m.lastFlap) > flapResetInterval {
+		m.flaps = 0
+	}
+	m.flaps++
+	m.lastFlap = now
+	flaps := m.flaps
+	m.mu.Unlock()
+
+	if config.IsZero() {
+		return
+	}
+
+	if flaps > maxFlaps {
+		health.SetDNSHealth(fmt.Errorf("/etc/resolv.conf is repeatedly being overwritten by something else on this system, giving up trying to keep Tailscale's DNS config installed"))
+		return
+	}
+
+	m.logf("dns: direct: /etc/resolv.conf was overwritten by a third party, reclaiming it")
+	if err := m.installConfig(config); err != nil {
+		m.logf("dns: direct: reclaiming /etc/resolv.conf: %v", err)
+		return
+	}
+	health.SetDNSHealth(nil)
+}
+
 func atomicWriteFile(fs wholeFileFS, filename string, data []byte, perm os.FileMode) error {
 	var randBytes [12]byte
 	if _, err := rand.Read(randBytes[:]); err != nil {
@@ -346,11 +533,37 @@ func atomicWriteFile(fs wholeFileFS, filename string, data []byte, perm os.FileM
 //
 // All name parameters are absolute paths.
 type wholeFileFS interface {
-	Stat(name string) (isRegular bool, err error)
+	Stat(name string) (os.FileInfo, error)
 	Rename(oldName, newName string) error
 	Remove(name string) error
 	ReadFile(name string) ([]byte, error)
 	WriteFile(name string, contents []byte, perm os.FileMode) error
+
+	// Watch returns a channel on which an empty struct is sent
+	// whenever name might have changed on disk, along with a stop
+	// function to release the watch. The channel is closed after stop
+	// is called or if watching fails permanently.
+	Watch(name string) (events <-chan struct{}, stop func(), err error)
+
+	// ReadMeta returns the metadata (owner, group, extended
+	// attributes, SELinux context) of name, so that it can be
+	// reapplied to a replacement file with WriteMeta. ok is false if
+	// name doesn't exist or metadata isn't supported on this platform.
+	ReadMeta(name string) (meta fileMeta, ok bool, err error)
+
+	// WriteMeta applies meta, previously returned by ReadMeta, to
+	// name.
+	WriteMeta(name string, meta fileMeta) error
+}
+
+// fileMeta carries the pieces of a file's metadata that a naive
+// write-a-new-file-and-rename-it-into-place update would otherwise
+// silently drop: its owning uid/gid, its extended attributes, and (on
+// SELinux systems) its security context.
+type fileMeta struct {
+	uid, gid int
+	xattrs   map[string][]byte
+	selinux  string // SELinux security context label, or "" if not applicable
 }
 
 // directFS is a wholeFileFS implemented directly on the OS.
@@ -364,12 +577,8 @@ type directFS struct {
 
 func (fs directFS) path(name string) string { return filepath.Join(fs.prefix, name) }
 
-func (fs directFS) Stat(name string) (isRegular bool, err error) {
-	fi, err := os.Stat(fs.path(name))
-	if err != nil {
-		return false, err
-	}
-	return fi.Mode().IsRegular(), nil
+func (fs directFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(fs.path(name))
 }
 
 func (fs directFS) Rename(oldName, newName string) error {
@@ -386,6 +595,54 @@ func (fs directFS) WriteFile(name string, contents []byte, perm os.FileMode) err
 	return ioutil.WriteFile(fs.path(name), contents, perm)
 }
 
+// Watch implements wholeFileFS.Watch using inotify (via fsnotify). It
+// watches name's parent directory, rather than name itself, so that
+// it notices the file being replaced wholesale (renamed over or
+// deleted and recreated) and not just written to in place.
+func (fs directFS) Watch(name string) (events <-chan struct{}, stop func(), err error) {
+	path := fs.path(name)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, nil, err
+	}
+
+	ch := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-done:
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != path {
+					continue
+				}
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+
+	return ch, func() {
+		close(done)
+		watcher.Close()
+	}, nil
+}
+
 // runningAsGUIDesktopUser reports whether it seems that this code is
 // being run as a regular user on a Linux desktop. This is a quick
 // hack to fix Issue 2672 where PolicyKit pops up a GUI dialog asking
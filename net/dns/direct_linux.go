@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/opencontainers/selinux/go-selinux"
+	"golang.org/x/sys/unix"
+)
+
+// xattrListSize is the buffer size used to list a file's extended
+// attribute names. Resolv.conf is not expected to carry an unusual
+// number of xattrs, so a fixed buffer keeps this simple; Listxattr
+// returns ERANGE if it's too small, which we surface as an error.
+const xattrListSize = 4096
+
+// ReadMeta implements wholeFileFS.ReadMeta.
+func (fs directFS) ReadMeta(name string) (meta fileMeta, ok bool, err error) {
+	path := fs.path(name)
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fileMeta{}, false, nil
+		}
+		return fileMeta{}, false, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		// Not a platform where we know how to get owner info; nothing
+		// more we can do.
+		return fileMeta{}, false, nil
+	}
+	meta.uid = int(st.Uid)
+	meta.gid = int(st.Gid)
+
+	listBuf := make([]byte, xattrListSize)
+	n, err := unix.Listxattr(path, listBuf)
+	if err != nil {
+		return fileMeta{}, false, fmt.Errorf("listing xattrs on %s: %w", path, err)
+	}
+	meta.xattrs = map[string][]byte{}
+	for _, attr := range splitNullTerminated(listBuf[:n]) {
+		// security.selinux is captured separately, via selinux.FileLabel
+		// below, which knows how to read and replay it correctly. If we
+		// also captured it here, a failure in the generic Setxattr replay
+		// in WriteMeta (e.g. EINTR, which that loop doesn't retry on)
+		// would return early and skip the dedicated SELinux handling
+		// entirely.
+		if attr == "security.selinux" {
+			continue
+		}
+		val, err := unix.Getxattr(path, attr, nil)
+		if err != nil {
+			continue
+		}
+		buf := make([]byte, val)
+		if _, err := unix.Getxattr(path, attr, buf); err == nil {
+			meta.xattrs[attr] = buf
+		}
+	}
+
+	if label, err := selinux.FileLabel(path); err == nil {
+		meta.selinux = label
+	}
+
+	return meta, true, nil
+}
+
+// WriteMeta implements wholeFileFS.WriteMeta.
+func (fs directFS) WriteMeta(name string, meta fileMeta) error {
+	path := fs.path(name)
+	if err := os.Chown(path, meta.uid, meta.gid); err != nil {
+		return fmt.Errorf("chown %s: %w", path, err)
+	}
+	for attr, val := range meta.xattrs {
+		if err := unix.Setxattr(path, attr, val, 0); err != nil {
+			return fmt.Errorf("setxattr %s %s: %w", path, attr, err)
+		}
+	}
+	if meta.selinux != "" {
+		if err := selinux.SetFileLabel(path, meta.selinux); err != nil {
+			return fmt.Errorf("setting SELinux label on %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// splitNullTerminated splits a Listxattr-style buffer of
+// NUL-terminated attribute names into individual strings.
+func splitNullTerminated(b []byte) []string {
+	var names []string
+	for _, chunk := range bytes.Split(b, []byte{0}) {
+		if len(chunk) > 0 {
+			names = append(names, string(chunk))
+		}
+	}
+	return names
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2020 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package dns
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDirectFSReadWriteMetaXattrs(t *testing.T) {
+	dir := t.TempDir()
+	fs := directFS{prefix: dir}
+
+	const name = "/resolv.conf"
+	if err := fs.WriteFile(name, []byte("nameserver 100.100.100.100\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := unix.Setxattr(path, "user.tailscale.test", []byte("hello"), 0); err != nil {
+		t.Skipf("xattrs not supported on this filesystem: %v", err)
+	}
+
+	meta, ok, err := fs.ReadMeta(name)
+	if err != nil {
+		t.Fatalf("ReadMeta: %v", err)
+	}
+	if !ok {
+		t.Fatalf("ReadMeta: ok=false, want true")
+	}
+	if got := meta.xattrs["user.tailscale.test"]; string(got) != "hello" {
+		t.Errorf("xattr user.tailscale.test = %q, want %q", got, "hello")
+	}
+
+	// Replace the file, as SetDNS's atomic write would, then reapply
+	// the captured metadata and check it round-tripped.
+	if err := fs.WriteFile(name, []byte("nameserver 8.8.8.8\n"), 0644); err != nil {
+		t.Fatalf("WriteFile (replace): %v", err)
+	}
+	if err := fs.WriteMeta(name, meta); err != nil {
+		t.Fatalf("WriteMeta: %v", err)
+	}
+
+	got, err := unix.Getxattr(path, "user.tailscale.test", nil)
+	if err != nil {
+		t.Fatalf("Getxattr size probe: %v", err)
+	}
+	buf := make([]byte, got)
+	if _, err := unix.Getxattr(path, "user.tailscale.test", buf); err != nil {
+		t.Fatalf("Getxattr: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("xattr after WriteMeta = %q, want %q", buf, "hello")
+	}
+}
+
+func TestDirectFSReadMetaSkipsSecuritySELinuxXattr(t *testing.T) {
+	dir := t.TempDir()
+	fs := directFS{prefix: dir}
+
+	const name = "/resolv.conf"
+	if err := fs.WriteFile(name, []byte("nameserver 100.100.100.100\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := unix.Setxattr(path, "security.selinux", []byte("unconfined_u:object_r:etc_t:s0"), 0); err != nil {
+		t.Skipf("setting security.selinux not permitted on this filesystem: %v", err)
+	}
+
+	meta, ok, err := fs.ReadMeta(name)
+	if err != nil {
+		t.Fatalf("ReadMeta: %v", err)
+	}
+	if !ok {
+		t.Fatalf("ReadMeta: ok=false, want true")
+	}
+	if _, captured := meta.xattrs["security.selinux"]; captured {
+		t.Errorf("meta.xattrs captured security.selinux; it must only be handled via meta.selinux, so a failed generic Setxattr replay in WriteMeta can't shadow the dedicated selinux.SetFileLabel call")
+	}
+}
+
+func TestDirectFSReadMetaMissingFile(t *testing.T) {
+	fs := directFS{prefix: t.TempDir()}
+	_, ok, err := fs.ReadMeta("/does-not-exist")
+	if err != nil {
+		t.Fatalf("ReadMeta: %v", err)
+	}
+	if ok {
+		t.Fatalf("ReadMeta of missing file: ok=true, want false")
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright (c) 2020 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package dns
+
+// ReadMeta implements wholeFileFS.ReadMeta. Extended file metadata
+// (owner, xattrs, SELinux context) is a Linux-specific concept, so
+// elsewhere ok is always false and SetDNS doesn't try to preserve it.
+func (fs directFS) ReadMeta(name string) (meta fileMeta, ok bool, err error) {
+	return fileMeta{}, false, nil
+}
+
+// WriteMeta implements wholeFileFS.WriteMeta. It's a no-op outside of
+// Linux; see ReadMeta.
+func (fs directFS) WriteMeta(name string, meta fileMeta) error {
+	return nil
+}
@@ -0,0 +1,265 @@
+// Copyright (c) 2020 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"inet.af/netaddr"
+	"tailscale.com/health"
+	"tailscale.com/util/dnsname"
+)
+
+// memFS is an in-memory wholeFileFS used to drive directManager.Run
+// without touching the real filesystem or a real inotify watch.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+
+	watchers []chan<- struct{}
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string][]byte{}}
+}
+
+func (fs *memFS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	bs, ok := fs.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return memFileInfo{name: filepath.Base(name), size: int64(len(bs))}, nil
+}
+
+// memFileInfo is a minimal os.FileInfo for a regular file, good
+// enough for the IsRegular() checks directManager performs.
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func (fs *memFS) ReadMeta(name string) (fileMeta, bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.files[name]; !ok {
+		return fileMeta{}, false, nil
+	}
+	return fileMeta{}, false, nil
+}
+
+func (fs *memFS) WriteMeta(name string, meta fileMeta) error {
+	return nil
+}
+
+func (fs *memFS) Rename(oldName, newName string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	bs, ok := fs.files[oldName]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(fs.files, oldName)
+	fs.files[newName] = bs
+	return nil
+}
+
+func (fs *memFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.files[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *memFS) ReadFile(name string) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	bs, ok := fs.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return bs, nil
+}
+
+func (fs *memFS) WriteFile(name string, contents []byte, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.files[name] = append([]byte(nil), contents...)
+	return nil
+}
+
+func (fs *memFS) Watch(name string) (<-chan struct{}, func(), error) {
+	ch := make(chan struct{}, 1)
+	fs.mu.Lock()
+	fs.watchers = append(fs.watchers, ch)
+	fs.mu.Unlock()
+	return ch, func() {}, nil
+}
+
+// clobber simulates a third party overwriting name, and notifies every
+// registered watcher, whatever name it was watching.
+func (fs *memFS) clobber(name string, contents []byte) {
+	fs.mu.Lock()
+	fs.files[name] = contents
+	watchers := fs.watchers
+	fs.mu.Unlock()
+	for _, ch := range watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func TestDirectManagerReclaimsAfterThirdPartyOverwrite(t *testing.T) {
+	fs := newMemFS()
+	m := newDirectManagerOnFS(t.Logf, fs)
+
+	cfg := OSConfig{
+		Nameservers: []netaddr.IP{netaddr.MustParseIP("100.100.100.100")},
+	}
+	if err := m.SetDNS(cfg); err != nil {
+		t.Fatalf("SetDNS: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx) }()
+
+	fs.clobber(resolvConf, []byte("nameserver 8.8.8.8\n"))
+
+	deadline := time.After(2 * time.Second)
+	for {
+		bs, _ := fs.ReadFile(resolvConf)
+		if bytes.Contains(bs, []byte("generated by tailscale")) {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("directManager did not reclaim resolv.conf in time, last contents: %q", bs)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	<-done
+}
+
+// TestDirectManagerCircuitBreaker drives reclaimIfNeeded directly
+// (rather than through Run's debounce loop) to check that it gives up
+// reclaiming and reports a health warning after maxFlaps consecutive
+// takeovers, and resumes once flapResetInterval has passed.
+func TestDirectManagerCircuitBreaker(t *testing.T) {
+	fs := newMemFS()
+	m := newDirectManagerOnFS(t.Logf, fs)
+
+	cfg := OSConfig{
+		Nameservers: []netaddr.IP{netaddr.MustParseIP("100.100.100.100")},
+	}
+	if err := m.SetDNS(cfg); err != nil {
+		t.Fatalf("SetDNS: %v", err)
+	}
+
+	healthErrs := make(chan error, maxFlaps+2)
+	unregister := health.RegisterWatcher(func(key health.Subsystem, err error) {
+		if key == health.SysDNS {
+			healthErrs <- err
+		}
+	})
+	defer unregister()
+
+	// Each of the first maxFlaps overwrites should still be reclaimed.
+	for i := 0; i < maxFlaps; i++ {
+		fs.clobber(resolvConf, []byte("nameserver 8.8.8.8\n"))
+		m.reclaimIfNeeded()
+		if bs, _ := fs.ReadFile(resolvConf); !bytes.Contains(bs, []byte("generated by tailscale")) {
+			t.Fatalf("flap %d: resolv.conf was not reclaimed: %q", i, bs)
+		}
+	}
+
+	// The next overwrite pushes us past maxFlaps: reclaimIfNeeded
+	// should give up instead of reclaiming again.
+	fs.clobber(resolvConf, []byte("nameserver 8.8.8.8\n"))
+	m.reclaimIfNeeded()
+	if bs, _ := fs.ReadFile(resolvConf); bytes.Contains(bs, []byte("generated by tailscale")) {
+		t.Fatalf("circuit breaker did not trip: resolv.conf was reclaimed past maxFlaps")
+	}
+
+	select {
+	case err := <-healthErrs:
+		if err == nil {
+			t.Fatalf("health.SetDNSHealth(nil) reported, want a non-nil warning once the circuit breaker trips")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("health.SetDNSHealth was never called after exceeding maxFlaps")
+	}
+
+	// Simulate flapResetInterval having elapsed, and check that
+	// reclaiming resumes and the health warning clears.
+	m.mu.Lock()
+	m.lastFlap = time.Now().Add(-2 * flapResetInterval)
+	m.mu.Unlock()
+
+	fs.clobber(resolvConf, []byte("nameserver 8.8.8.8\n"))
+	m.reclaimIfNeeded()
+	if bs, _ := fs.ReadFile(resolvConf); !bytes.Contains(bs, []byte("generated by tailscale")) {
+		t.Fatalf("resolv.conf was not reclaimed after flapResetInterval elapsed: %q", bs)
+	}
+
+	select {
+	case err := <-healthErrs:
+		if err != nil {
+			t.Fatalf("health.SetDNSHealth(%v) reported, want nil once reclaiming resumes", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("health.SetDNSHealth was never cleared after reclaiming resumed")
+	}
+}
+
+func TestWriteReadResolvOptions(t *testing.T) {
+	want := OSConfig{
+		Nameservers:   []netaddr.IP{netaddr.MustParseIP("100.100.100.100")},
+		SearchDomains: []dnsname.FQDN{dnsname.FQDN("corp.example.com.")},
+		Options:       []string{"ndots:1", "timeout:2", "attempts:2"},
+	}
+
+	var buf bytes.Buffer
+	writeResolvConf(&buf, want.Nameservers, want.SearchDomains, want.Options)
+
+	got, err := readResolv(&buf)
+	if err != nil {
+		t.Fatalf("readResolv: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round-trip mismatch:\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestWriteResolvConfNoOptions(t *testing.T) {
+	var buf bytes.Buffer
+	writeResolvConf(&buf, nil, nil, nil)
+	if bytes.Contains(buf.Bytes(), []byte("options")) {
+		t.Errorf("writeResolvConf with no options wrote an options line: %q", buf.String())
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2020 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"inet.af/netaddr"
+	"tailscale.com/util/dnsname"
+)
+
+// OSConfigurator is the interface that different OS-specific DNS
+// configuration mechanisms implement to let Tailscale manage the
+// system's DNS settings.
+type OSConfigurator interface {
+	// SetDNS updates the OS's DNS configuration to match cfg. If
+	// cfg is the zero value, the OS's DNS configuration is restored
+	// to whatever it was before Tailscale started managing it.
+	SetDNS(cfg OSConfig) error
+	// SupportsSplitDNS reports whether this configurator can support
+	// routing DNS requests for subdomains to a specific resolver.
+	SupportsSplitDNS() bool
+	// GetBaseConfig returns the OS's "natural" DNS configuration, as
+	// it would be in the absence of Tailscale.
+	GetBaseConfig() (OSConfig, error)
+	// Close removes Tailscale's DNS configuration and restores the
+	// previous configuration.
+	Close() error
+}
+
+// OSConfig is an OS DNS configuration.
+type OSConfig struct {
+	// Nameservers are the IP addresses of the nameservers to use.
+	Nameservers []netaddr.IP
+	// SearchDomains are the domain suffixes to search when resolving
+	// single-label names.
+	SearchDomains []dnsname.FQDN
+	// Options are additional resolv.conf(5) "options" values to set,
+	// e.g. "ndots:1" or "timeout:2". Order is preserved when writing
+	// them out. If empty, callers should apply a sensible default
+	// rather than leaving the system's options unset.
+	Options []string
+}
+
+// IsZero reports whether o is the zero value.
+func (o OSConfig) IsZero() bool {
+	return len(o.Nameservers) == 0 && len(o.SearchDomains) == 0 && len(o.Options) == 0
+}
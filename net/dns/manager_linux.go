@@ -0,0 +1,28 @@
+// Copyright (c) 2020 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"io/ioutil"
+
+	"tailscale.com/types/logger"
+)
+
+// newOSConfigurator picks the OSConfigurator best suited to this
+// Linux system's resolv.conf management, preferring a subscriber
+// protocol (resolvconf) over directly overwriting /etc/resolv.conf
+// when one is in use, since rewriting the file out from under
+// resolvconf just means it gets regenerated and our changes lost.
+func newOSConfigurator(logf logger.Logf) (OSConfigurator, error) {
+	// A missing or unreadable resolv.conf just means we can't detect
+	// an existing owner; fall through to directManager in that case.
+	if bs, err := ioutil.ReadFile(resolvConf); err == nil && isResolvconfActive(bs) {
+		if rc, err := newResolvconfManager(logf); err == nil {
+			return rc, nil
+		}
+	}
+
+	return newDirectManager(logf), nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2020 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"tailscale.com/types/logger"
+)
+
+// resolvconfInterface is the name resolvconfManager registers its
+// fragment under with resolvconf(8). Using a fixed, Tailscale-specific
+// interface name means we can update or remove our fragment without
+// disturbing the ones owned by other interfaces.
+const resolvconfInterface = "tailscale.inet"
+
+// resolvconfManager is an OSConfigurator which uses the resolvconf(8)
+// subscriber protocol (as implemented by openresolv and Debian's
+// resolvconf package) to install Tailscale's DNS configuration,
+// instead of overwriting /etc/resolv.conf directly.
+//
+// This avoids fighting with resolvconf, which regenerates
+// /etc/resolv.conf from the fragments under /run/resolvconf/interface
+// any time any interface's configuration changes.
+type resolvconfManager struct {
+	logf logger.Logf
+
+	// run executes the resolvconf binary with the given args and
+	// stdin, and returns its combined output. It's a func field,
+	// rather than a direct exec.Command call, so that tests can
+	// substitute a fake resolvconf without needing the real binary
+	// installed.
+	run func(args []string, stdin []byte) (out []byte, err error)
+}
+
+// resolvconfPath is the default location of the resolvconf binary,
+// overridable in tests.
+var resolvconfPath = "/sbin/resolvconf"
+
+func newResolvconfManager(logf logger.Logf) (*resolvconfManager, error) {
+	return &resolvconfManager{
+		logf: logf,
+		run:  runResolvconfBinary,
+	}, nil
+}
+
+// runResolvconfBinary is the default resolvconfManager.run
+// implementation, which shells out to the real resolvconf(8) binary.
+func runResolvconfBinary(args []string, stdin []byte) ([]byte, error) {
+	cmd := exec.Command(resolvconfPath, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	return cmd.CombinedOutput()
+}
+
+func (m *resolvconfManager) SetDNS(config OSConfig) error {
+	if config.IsZero() {
+		return m.runResolvconf("-d", resolvconfInterface)
+	}
+
+	var stdin bytes.Buffer
+	writeResolvConf(&stdin, config.Nameservers, config.SearchDomains, resolvOptionsOrDefault(config.Options))
+
+	out, err := m.run([]string{"-a", resolvconfInterface}, stdin.Bytes())
+	if err != nil {
+		return fmt.Errorf("running resolvconf -a %s: %w: %s", resolvconfInterface, err, out)
+	}
+	return nil
+}
+
+func (m *resolvconfManager) runResolvconf(args ...string) error {
+	out, err := m.run(args, nil)
+	if err != nil {
+		return fmt.Errorf("running resolvconf %v: %w: %s", args, err, out)
+	}
+	return nil
+}
+
+func (m *resolvconfManager) SupportsSplitDNS() bool {
+	return false
+}
+
+// GetBaseConfig returns the config as it would appear in the absence
+// of Tailscale's resolvconf fragment. resolvconf -i lists every
+// fragment currently registered; we re-render the merged view from
+// all of them except our own, rather than reading resolvconf's
+// merged output directly, since that output already has our fragment
+// blended in and would hand split-DNS callers a config that forwards
+// non-Tailscale queries back through Tailscale's own resolver.
+func (m *resolvconfManager) GetBaseConfig() (OSConfig, error) {
+	bs, err := m.run([]string{"-i"}, nil)
+	if err != nil {
+		return OSConfig{}, fmt.Errorf("listing resolvconf interfaces: %w", err)
+	}
+
+	var others []string
+	for _, f := range strings.Fields(string(bs)) {
+		if f == resolvconfInterface {
+			continue
+		}
+		others = append(others, f)
+	}
+
+	merged, err := m.run(append([]string{"-l"}, others...), nil)
+	if err != nil {
+		return OSConfig{}, fmt.Errorf("listing resolvconf base config: %w", err)
+	}
+	return readResolv(bytes.NewReader(merged))
+}
+
+func (m *resolvconfManager) Close() error {
+	return m.runResolvconf("-d", resolvconfInterface)
+}
+
+// isResolvconfActive reports whether the system appears to be using
+// openresolv/resolvconf to manage /etc/resolv.conf, and that the
+// resolvconf binary is actually present so we can talk to it.
+func isResolvconfActive(bs []byte) bool {
+	if resolvOwner(bs) != "resolvconf" {
+		return false
+	}
+	_, err := exec.LookPath(resolvconfPath)
+	return err == nil
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2020 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"inet.af/netaddr"
+)
+
+func TestResolvconfManagerSetDNS(t *testing.T) {
+	var gotArgs []string
+	var gotStdin []byte
+	m := &resolvconfManager{
+		logf: t.Logf,
+		run: func(args []string, stdin []byte) ([]byte, error) {
+			gotArgs = args
+			gotStdin = stdin
+			return nil, nil
+		},
+	}
+
+	cfg := OSConfig{
+		Nameservers: []netaddr.IP{netaddr.MustParseIP("100.100.100.100")},
+	}
+	if err := m.SetDNS(cfg); err != nil {
+		t.Fatalf("SetDNS: %v", err)
+	}
+
+	wantArgs := []string{"-a", resolvconfInterface}
+	if !reflect.DeepEqual(gotArgs, wantArgs) {
+		t.Errorf("resolvconf args = %v, want %v", gotArgs, wantArgs)
+	}
+
+	var wantStdin bytes.Buffer
+	writeResolvConf(&wantStdin, cfg.Nameservers, cfg.SearchDomains, tailscaleOptions)
+	if string(gotStdin) != wantStdin.String() {
+		t.Errorf("resolvconf stdin = %q, want %q (Tailscale default options should apply when OSConfig.Options is empty)", gotStdin, wantStdin.String())
+	}
+}
+
+func TestResolvconfManagerSetDNSZero(t *testing.T) {
+	var gotArgs []string
+	m := &resolvconfManager{
+		logf: t.Logf,
+		run: func(args []string, stdin []byte) ([]byte, error) {
+			gotArgs = args
+			return nil, nil
+		},
+	}
+
+	if err := m.SetDNS(OSConfig{}); err != nil {
+		t.Fatalf("SetDNS: %v", err)
+	}
+
+	wantArgs := []string{"-d", resolvconfInterface}
+	if !reflect.DeepEqual(gotArgs, wantArgs) {
+		t.Errorf("resolvconf args = %v, want %v", gotArgs, wantArgs)
+	}
+}
+
+func TestResolvconfManagerClose(t *testing.T) {
+	var gotArgs []string
+	m := &resolvconfManager{
+		logf: t.Logf,
+		run: func(args []string, stdin []byte) ([]byte, error) {
+			gotArgs = args
+			return nil, nil
+		},
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	wantArgs := []string{"-d", resolvconfInterface}
+	if !reflect.DeepEqual(gotArgs, wantArgs) {
+		t.Errorf("resolvconf args = %v, want %v", gotArgs, wantArgs)
+	}
+}
+
+func TestResolvconfManagerGetBaseConfig(t *testing.T) {
+	var gotArgs [][]string
+	m := &resolvconfManager{
+		logf: t.Logf,
+		run: func(args []string, stdin []byte) ([]byte, error) {
+			gotArgs = append(gotArgs, args)
+			switch args[0] {
+			case "-i":
+				return []byte("eth0 " + resolvconfInterface + " wlan0\n"), nil
+			case "-l":
+				return []byte("nameserver 192.0.2.1\n"), nil
+			}
+			t.Fatalf("unexpected resolvconf args %v", args)
+			return nil, nil
+		},
+	}
+
+	got, err := m.GetBaseConfig()
+	if err != nil {
+		t.Fatalf("GetBaseConfig: %v", err)
+	}
+	want := OSConfig{Nameservers: []netaddr.IP{netaddr.MustParseIP("192.0.2.1")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetBaseConfig = %+v, want %+v", got, want)
+	}
+
+	if len(gotArgs) != 2 || gotArgs[0][0] != "-i" {
+		t.Fatalf("unexpected resolvconf invocations: %v", gotArgs)
+	}
+	wantListArgs := []string{"-l", "eth0", "wlan0"}
+	if !reflect.DeepEqual(gotArgs[1], wantListArgs) {
+		t.Errorf("resolvconf -l args = %v, want %v (should exclude %s)", gotArgs[1], wantListArgs, resolvconfInterface)
+	}
+}
+
+// TestIsResolvconfActiveRequiresResolvconfOwner checks the
+// resolvOwner-based half of isResolvconfActive: it should never
+// report resolvconf as active when resolv.conf doesn't identify
+// resolvconf as the owner, regardless of whether the resolvconf
+// binary happens to be installed on the machine running the test.
+func TestIsResolvconfActiveRequiresResolvconfOwner(t *testing.T) {
+	tests := []struct {
+		name string
+		bs   []byte
+	}{
+		{"empty", nil},
+		{"systemd-resolved", []byte("# Managed by systemd-resolved\nnameserver 127.0.0.53\n")},
+		{"no-owner-comment", []byte("nameserver 192.0.2.1\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isResolvconfActive(tt.bs); got {
+				t.Errorf("isResolvconfActive(%q) = true, want false", tt.bs)
+			}
+		})
+	}
+}